@@ -0,0 +1,197 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsResolveRequest is the JSON body POSTed to /dns/resolve.
+type dnsResolveRequest struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`               // e.g. "A", "AAAA", "MX"; defaults to "A"
+	Resolver  string `json:"resolver,omitempty"` // host:port for udp/tcp/dot, or a URL for doh; ignored for system/magicdns
+	Transport string `json:"transport"`          // udp, tcp, dot, doh, system, magicdns
+}
+
+// dnsResolveResponse is the full answer to a /dns/resolve request.
+type dnsResolveResponse struct {
+	Question   []dns.Question `json:"question"`
+	Answer     []dns.RR       `json:"answer"`
+	Authority  []dns.RR       `json:"authority"`
+	Additional []dns.RR       `json:"additional"`
+	Rcode      string         `json:"rcode"`
+	RTTMillis  float64        `json:"rttMillis"`
+	Resolver   string         `json:"resolver"` // the resolver actually used
+}
+
+// handleDNSResolve implements POST /dns/resolve: see dnsResolveRequest and
+// dnsResolveResponse for the wire format. It's implemented directly on top
+// of github.com/miekg/dns for wire-level control, so tests can assert on
+// things like truncation, EDNS, DNSSEC bits, and CNAME chains that a
+// wrapper around "dig" output couldn't expose.
+func handleDNSResolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	var req dnsResolveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad dns resolve request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(req.Type)]
+	if req.Type == "" {
+		qtype = dns.TypeA
+	} else if !ok {
+		http.Error(w, fmt.Sprintf("unknown type %q", req.Type), http.StatusBadRequest)
+		return
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(req.Name), qtype)
+	m.RecursionDesired = true
+
+	t0 := time.Now()
+	var (
+		resp     *dns.Msg
+		resolver string
+		err      error
+	)
+	switch req.Transport {
+	case "magicdns":
+		resp, resolver, err = resolveMagicDNS(r.Context(), m)
+	case "udp", "tcp", "dot":
+		resolver = req.Resolver
+		if resolver == "" {
+			http.Error(w, "resolver is required for transport "+req.Transport, http.StatusBadRequest)
+			return
+		}
+		resp, err = resolveClassic(req.Transport, resolver, m)
+	case "doh":
+		resolver = req.Resolver
+		if resolver == "" {
+			http.Error(w, "resolver (a DoH URL) is required for transport doh", http.StatusBadRequest)
+			return
+		}
+		resp, err = resolveDoH(r.Context(), resolver, m)
+	case "system":
+		resp, resolver, err = resolveSystem(m)
+	default:
+		http.Error(w, fmt.Sprintf("unknown transport %q", req.Transport), http.StatusBadRequest)
+		return
+	}
+	rtt := time.Since(t0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	out := dnsResolveResponse{
+		Question:   resp.Question,
+		Answer:     resp.Answer,
+		Authority:  resp.Ns,
+		Additional: resp.Extra,
+		Rcode:      dns.RcodeToString[resp.Rcode],
+		RTTMillis:  float64(rtt) / float64(time.Millisecond),
+		Resolver:   resolver,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// resolveClassic issues the query over plain UDP, TCP, or DNS-over-TLS.
+func resolveClassic(transport, resolver string, m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Timeout: 5 * time.Second}
+	switch transport {
+	case "udp":
+		c.Net = "udp"
+	case "tcp":
+		c.Net = "tcp"
+	case "dot":
+		c.Net = "tcp-tls"
+	}
+	resp, _, err := c.Exchange(m, resolver)
+	return resp, err
+}
+
+// resolveDoH issues the query as a DNS-over-HTTPS POST per RFC 8484.
+func resolveDoH(ctx context.Context, resolverURL string, m *dns.Msg) (*dns.Msg, error) {
+	wire, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", resolverURL, bytes.NewReader(wire))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	hresp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh resolver returned %s", hresp.Status)
+	}
+	body, err := io.ReadAll(hresp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(body); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// resolveSystem issues the query against the first nameserver in
+// /etc/resolv.conf, i.e. whatever the node would use by default.
+func resolveSystem(m *dns.Msg) (resp *dns.Msg, resolver string, err error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(conf.Servers) == 0 {
+		return nil, "", errors.New("no nameservers in /etc/resolv.conf")
+	}
+	resolver = conf.Servers[0] + ":" + conf.Port
+	resp, err = resolveClassic("udp", resolver, m)
+	return resp, resolver, err
+}
+
+// resolveMagicDNS routes the query through LocalClient.QueryDNS, the same
+// helper "tailscale debug dns-query" uses, which in turn hits tailscaled's
+// /localapi/v0/dns-query with plain name/type parameters and gets back a
+// JSON-wrapped {bytes, resolvers} response.
+func resolveMagicDNS(ctx context.Context, m *dns.Msg) (resp *dns.Msg, resolver string, err error) {
+	q := m.Question[0]
+	wire, resolvers, err := eventsLC.QueryDNS(ctx, strings.TrimSuffix(q.Name, "."), dns.TypeToString[q.Qtype])
+	if err != nil {
+		return nil, "", err
+	}
+	resp = new(dns.Msg)
+	if err := resp.Unpack(wire); err != nil {
+		return nil, "", err
+	}
+	if len(resolvers) > 0 {
+		resolver = resolvers[0].Addr
+	}
+	return resp, resolver, nil
+}
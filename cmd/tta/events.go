@@ -0,0 +1,259 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale"
+)
+
+// eventKind identifies the kind of an event on the /events stream.
+type eventKind string
+
+const (
+	eventKindNetmap eventKind = "netmap" // a netmap was delivered; data is *netmap.NetworkMap
+	eventKindState  eventKind = "state"  // the rest of an ipn.Notify (login state, prefs, etc.)
+	eventKindStatus eventKind = "status" // a periodic ipnstate.Status, sent only when it changes
+	eventKindPcap   eventKind = "pcap"   // a captured packet, base64-encoded pcapng block
+)
+
+// event is one line of the newline-delimited JSON /events stream.
+//
+// Seq is only meaningful for events that came from the shared eventRing
+// (netmap, state, status): it's the resume cursor to pass back as the
+// "since" query parameter on reconnect. Pcap frames aren't buffered in
+// the ring at all — each /events connection starts its own capture — so
+// they carry their own, separate PcapSeq instead of reusing Seq; a driver
+// that fed PcapSeq back as "since" would make the ring silently skip
+// buffered events it never actually saw.
+type event struct {
+	Seq     uint64          `json:"seq,omitempty"`
+	PcapSeq uint64          `json:"pcapSeq,omitempty"`
+	Kind    eventKind       `json:"kind"`
+	Time    time.Time       `json:"time"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// eventRing buffers the most recent events so a driver that reconnects to
+// /events with a resume cursor (the "since" query parameter) doesn't miss
+// anything that happened while it was disconnected, as long as it
+// reconnects before the ring wraps.
+type eventRing struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     []event
+	nextSeq uint64
+	max     int
+}
+
+func newEventRing(max int) *eventRing {
+	r := &eventRing{max: max}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+func (r *eventRing) add(kind eventKind, data any) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("eventRing: marshal %s event: %v", kind, err)
+		return
+	}
+	r.mu.Lock()
+	e := event{Seq: r.nextSeq, Kind: kind, Time: time.Now(), Data: b}
+	r.nextSeq++
+	r.buf = append(r.buf, e)
+	if len(r.buf) > r.max {
+		r.buf = r.buf[len(r.buf)-r.max:]
+	}
+	r.mu.Unlock()
+	r.cond.Broadcast()
+}
+
+// since returns the buffered events with Seq >= seq, and the seq value the
+// caller should pass next time to resume from this point. If the caller's
+// cursor is older than everything still buffered, some events have been
+// lost and the returned slice starts from whatever's left.
+func (r *eventRing) since(seq uint64) (events []event, next uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.buf {
+		if e.Seq >= seq {
+			events = append(events, e)
+		}
+	}
+	return events, r.nextSeq
+}
+
+// wait blocks until either ctx is done or there's at least one event with
+// Seq >= since.
+func (r *eventRing) wait(ctx context.Context, since uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stop := context.AfterFunc(ctx, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.cond.Broadcast()
+	})
+	defer stop()
+	for r.nextSeq <= since && ctx.Err() == nil {
+		r.cond.Wait()
+	}
+}
+
+var events = newEventRing(1024)
+
+var eventsLC tailscale.LocalClient
+
+// startEventSources starts the background watchers that feed the shared
+// event ring. It's called once from main.
+func startEventSources() {
+	go watchIPNBus()
+	go pollStatus()
+}
+
+func watchIPNBus() {
+	for {
+		watcher, err := eventsLC.WatchIPNBus(context.Background(), 0)
+		if err != nil {
+			log.Printf("events: WatchIPNBus: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for {
+			n, err := watcher.Next()
+			if err != nil {
+				log.Printf("events: watcher.Next: %v", err)
+				break
+			}
+			if n.NetMap != nil {
+				events.add(eventKindNetmap, n.NetMap)
+			}
+			// Don't double-report the (possibly large) NetMap under the
+			// "state" kind too.
+			n.NetMap = nil
+			events.add(eventKindState, n)
+		}
+		watcher.Close()
+	}
+}
+
+// pollStatus periodically polls LocalClient.Status and emits a "status"
+// event whenever the JSON-serialized status changes, so that drivers that
+// don't want to reconstruct state purely from ipn.Notify can just diff
+// consecutive status events themselves.
+func pollStatus() {
+	var lastJSON string
+	for {
+		st, err := eventsLC.Status(context.Background())
+		if err != nil {
+			log.Printf("events: Status: %v", err)
+			time.Sleep(2 * time.Second)
+			continue
+		}
+		b, err := json.Marshal(st)
+		if err == nil && string(b) != lastJSON {
+			lastJSON = string(b)
+			events.add(eventKindStatus, st)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func parseKinds(s string) map[eventKind]bool {
+	if s == "" {
+		return nil // nil means "no filter"
+	}
+	m := make(map[eventKind]bool)
+	for _, k := range strings.Split(s, ",") {
+		m[eventKind(strings.TrimSpace(k))] = true
+	}
+	return m
+}
+
+func kindAllowed(want map[eventKind]bool, k eventKind) bool {
+	return want == nil || want[k]
+}
+
+// handleEvents implements GET /events?kinds=netmap,state&bpf=...&since=123.
+// It streams newline-delimited JSON events to the driver: first any
+// buffered events at or after the since cursor, then a live tail.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	q := r.URL.Query()
+	kinds := parseKinds(q.Get("kinds"))
+	var since uint64
+	if s := q.Get("since"); s != "" {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "bad since cursor", http.StatusBadRequest)
+			return
+		}
+		since = v
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	var writeMu sync.Mutex
+	writeEvent := func(e event) error {
+		if !kindAllowed(kinds, e.Kind) {
+			return nil
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	ctx := r.Context()
+
+	if kindAllowed(kinds, eventKindPcap) {
+		stop, err := startPcapStream(ctx, q.Get("bpf"), func(seq uint64, frame []byte) error {
+			return writeEvent(event{PcapSeq: seq, Kind: eventKindPcap, Time: time.Now(), Data: pcapFrameJSON(frame)})
+		})
+		if err != nil {
+			writeEvent(event{Kind: eventKindPcap, Time: time.Now(), Data: json.RawMessage(`{"error":` + strconv.Quote(err.Error()) + `}`)})
+		} else {
+			defer stop()
+		}
+	}
+
+	for {
+		evs, next := events.since(since)
+		for _, e := range evs {
+			if err := writeEvent(e); err != nil {
+				return
+			}
+		}
+		since = next
+		if ctx.Err() != nil {
+			return
+		}
+		events.wait(ctx, since)
+	}
+}
+
+func pcapFrameJSON(frame []byte) json.RawMessage {
+	b, _ := json.Marshal(map[string]any{"pcapng": frame})
+	return b
+}
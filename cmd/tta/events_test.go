@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEventRingSinceAndResume(t *testing.T) {
+	r := newEventRing(10)
+	r.add(eventKindState, map[string]int{"n": 1})
+	r.add(eventKindState, map[string]int{"n": 2})
+
+	evs, next := r.since(0)
+	if len(evs) != 2 {
+		t.Fatalf("since(0): got %d events, want 2", len(evs))
+	}
+	if evs[0].Seq != 0 || evs[1].Seq != 1 {
+		t.Errorf("unexpected seqs: %d, %d", evs[0].Seq, evs[1].Seq)
+	}
+	if next != 2 {
+		t.Errorf("next = %d, want 2", next)
+	}
+
+	// Resuming from the cursor the caller was handed back should only
+	// return events it hasn't seen yet.
+	evs, next = r.since(next)
+	if len(evs) != 0 {
+		t.Errorf("since(2) before new events: got %d, want 0", len(evs))
+	}
+
+	r.add(eventKindState, map[string]int{"n": 3})
+	evs, next = r.since(next)
+	if len(evs) != 1 || evs[0].Seq != 2 {
+		t.Fatalf("since(2) after new event: got %+v", evs)
+	}
+	if next != 3 {
+		t.Errorf("next = %d, want 3", next)
+	}
+}
+
+func TestEventRingWraparound(t *testing.T) {
+	r := newEventRing(3)
+	for i := 0; i < 5; i++ {
+		r.add(eventKindState, map[string]int{"n": i})
+	}
+	// Only the last 3 should still be buffered.
+	evs, next := r.since(0)
+	if len(evs) != 3 {
+		t.Fatalf("got %d buffered events, want 3", len(evs))
+	}
+	if evs[0].Seq != 2 || evs[2].Seq != 4 {
+		t.Errorf("unexpected seqs after wraparound: %d..%d", evs[0].Seq, evs[2].Seq)
+	}
+	if next != 5 {
+		t.Errorf("next = %d, want 5", next)
+	}
+}
+
+func TestEventRingWait(t *testing.T) {
+	r := newEventRing(10)
+	done := make(chan struct{})
+	go func() {
+		r.wait(context.Background(), 0)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("wait returned before any event was added")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.add(eventKindState, map[string]int{"n": 1})
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait didn't return after an event was added")
+	}
+}
+
+func TestEventRingWaitRespectsContext(t *testing.T) {
+	r := newEventRing(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.wait(ctx, 0)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait didn't return after context cancellation")
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Exec frame stream IDs, multiplexed onto a single chunked HTTP response
+// body. Each frame is a 1 byte stream ID followed by a big-endian uint32
+// length and then that many bytes of payload.
+const (
+	execStreamStdout   = 1
+	execStreamStderr   = 2
+	execStreamExit     = 3
+	execStreamStdinAck = 4
+)
+
+// execSpec is the JSON body POSTed to /exec.
+type execSpec struct {
+	Argv    []string      `json:"argv"`
+	Env     []string      `json:"env,omitempty"`
+	Cwd     string        `json:"cwd,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty"`
+	TTY     bool          `json:"tty,omitempty"` // currently unsupported; reserved for future use
+}
+
+// execExitResult is the JSON payload of the final (execStreamExit) frame.
+type execExitResult struct {
+	ExitCode int    `json:"exitCode"`
+	Err      string `json:"err,omitempty"` // os/exec error, if any, other than a plain non-zero exit
+}
+
+// execStdinAck is the JSON payload of an execStreamStdinAck frame, sent on
+// the main /exec stream whenever /exec/{id}/stdin accepts data or closes
+// stdin, so a driver watching only the framed stream still sees them.
+type execStdinAck struct {
+	Bytes  int64 `json:"bytes"`
+	Closed bool  `json:"closed,omitempty"`
+}
+
+// execProc is a running (or recently finished) process started by /exec,
+// addressable by the callers of /exec/{id}/signal and /exec/{id}/stdin.
+type execProc struct {
+	id        string
+	stdin     io.WriteCloser
+	cmd       *exec.Cmd
+	emitFrame func(streamID byte, payload []byte) error // writes a frame to the /exec stream
+
+	stdinMu sync.Mutex // serializes writes to stdin across concurrent /exec/{id}/stdin calls
+
+	mu      sync.Mutex
+	started bool // cmd.Start succeeded; proc.cmd.Process is non-nil
+	done    bool
+}
+
+var (
+	execMu    sync.Mutex
+	execProcs = map[string]*execProc{} // id -> proc, while running
+)
+
+func newExecID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func registerExecProc(p *execProc) {
+	execMu.Lock()
+	defer execMu.Unlock()
+	execProcs[p.id] = p
+}
+
+func unregisterExecProc(id string) {
+	execMu.Lock()
+	defer execMu.Unlock()
+	delete(execProcs, id)
+}
+
+func getExecProc(id string) *execProc {
+	execMu.Lock()
+	defer execMu.Unlock()
+	return execProcs[id]
+}
+
+// writeExecFrame writes a single framed chunk to w and flushes it so the
+// driver sees it as soon as possible.
+func writeExecFrame(w http.ResponseWriter, streamID byte, payload []byte) error {
+	var hdr [5]byte
+	hdr[0] = streamID
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if len(payload) > 0 {
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// frameWriter adapts an (io.Writer, stream ID) pair to io.Writer, framing
+// every Write call as its own chunk.
+type frameWriter struct {
+	w        http.ResponseWriter
+	streamID byte
+	mu       *sync.Mutex
+}
+
+func (fw frameWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if err := writeExecFrame(fw.w, fw.streamID, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// handleExec implements POST /exec: it runs a command and streams its
+// stdout, stderr and exit status back as framed chunks; see the
+// execStream* constants for the frame format.
+func handleExec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	var spec execSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, fmt.Sprintf("bad exec spec: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(spec.Argv) == 0 {
+		http.Error(w, "argv must be non-empty", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, spec.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, absify(spec.Argv[0]), spec.Argv[1:]...)
+	if spec.Cwd != "" {
+		cmd.Dir = spec.Cwd
+	}
+	if len(spec.Env) > 0 {
+		cmd.Env = spec.Env
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	id := newExecID()
+	proc := &execProc{id: id, stdin: stdin, cmd: cmd}
+
+	var writeMu sync.Mutex
+	streamClosed := false // guarded by writeMu; set once handleExec returns
+	cmd.Stdout = frameWriter{w, execStreamStdout, &writeMu}
+	cmd.Stderr = frameWriter{w, execStreamStderr, &writeMu}
+	proc.emitFrame = func(streamID byte, payload []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if streamClosed {
+			return errors.New("exec stream closed")
+		}
+		return writeExecFrame(w, streamID, payload)
+	}
+	defer func() {
+		writeMu.Lock()
+		streamClosed = true
+		writeMu.Unlock()
+	}()
+
+	// Register before the id is handed to the client (via the header
+	// below), so a driver that pipelines a stdin write right after
+	// reading X-TTA-Exec-Id can never race registerExecProc.
+	registerExecProc(proc)
+	defer unregisterExecProc(id)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-TTA-Exec-Id", id)
+	w.WriteHeader(http.StatusOK)
+
+	log.Printf("Got /exec %q (id %s)", spec.Argv, id)
+	if err := cmd.Start(); err != nil {
+		b, _ := json.Marshal(execExitResult{ExitCode: -1, Err: err.Error()})
+		writeExecFrame(w, execStreamExit, b)
+		return
+	}
+	proc.mu.Lock()
+	proc.started = true
+	proc.mu.Unlock()
+
+	err = cmd.Wait()
+	proc.mu.Lock()
+	proc.done = true
+	proc.mu.Unlock()
+
+	res := execExitResult{ExitCode: cmd.ProcessState.ExitCode()}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || res.ExitCode == -1 {
+			// Either not a plain exit status (e.g. an I/O error copying
+			// output), or no exit code at all (e.g. killed by context timeout).
+			res.Err = err.Error()
+		}
+	}
+	log.Printf("Did /exec %q (id %s): exit=%d err=%v", spec.Argv, id, res.ExitCode, err)
+	b, _ := json.Marshal(res)
+	writeMu.Lock()
+	writeExecFrame(w, execStreamExit, b)
+	writeMu.Unlock()
+}
+
+// handleExecSub routes /exec/{id}/stdin and /exec/{id}/signal, the two
+// control endpoints for a running exec.
+func handleExecSub(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/exec/")
+	id, action, ok := strings.Cut(rest, "/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch action {
+	case "stdin":
+		handleExecStdin(w, r, id)
+	case "signal":
+		handleExecSignal(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleExecStdin implements POST /exec/{id}/stdin: the request body is
+// copied verbatim to the process's stdin. Closing the request body (i.e.
+// an empty POST with no Content-Length and immediate EOF) is not treated
+// specially; send a DELETE to close stdin instead.
+func handleExecStdin(w http.ResponseWriter, r *http.Request, id string) {
+	proc := getExecProc(id)
+	if proc == nil {
+		http.Error(w, "no such exec id", http.StatusNotFound)
+		return
+	}
+	proc.stdinMu.Lock()
+	defer proc.stdinMu.Unlock()
+	if r.Method == "DELETE" {
+		proc.stdin.Close()
+		emitStdinAck(proc, execStdinAck{Closed: true})
+		io.WriteString(w, "OK\n")
+		return
+	}
+	n, err := io.Copy(proc.stdin, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	emitStdinAck(proc, execStdinAck{Bytes: n})
+	fmt.Fprintf(w, "wrote %d bytes\n", n)
+}
+
+// emitStdinAck writes an execStreamStdinAck frame to proc's /exec stream,
+// if that stream is still open.
+func emitStdinAck(proc *execProc, ack execStdinAck) {
+	if proc.emitFrame == nil {
+		return
+	}
+	b, _ := json.Marshal(ack)
+	proc.emitFrame(execStreamStdinAck, b)
+}
+
+// handleExecSignal implements POST /exec/{id}/signal?sig=TERM (or KILL,
+// INT, HUP, ...).
+func handleExecSignal(w http.ResponseWriter, r *http.Request, id string) {
+	proc := getExecProc(id)
+	if proc == nil {
+		http.Error(w, "no such exec id", http.StatusNotFound)
+		return
+	}
+	sig, err := parseSignal(r.URL.Query().Get("sig"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	proc.mu.Lock()
+	started, done := proc.started, proc.done
+	proc.mu.Unlock()
+	if done {
+		http.Error(w, "process already exited", http.StatusGone)
+		return
+	}
+	if !started {
+		http.Error(w, "process hasn't started yet", http.StatusConflict)
+		return
+	}
+	if err := proc.cmd.Process.Signal(sig); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	io.WriteString(w, "OK\n")
+}
+
+func parseSignal(s string) (syscall.Signal, error) {
+	switch s {
+	case "", "TERM":
+		return syscall.SIGTERM, nil
+	case "KILL":
+		return syscall.SIGKILL, nil
+	case "INT":
+		return syscall.SIGINT, nil
+	case "HUP":
+		return syscall.SIGHUP, nil
+	case "USR1":
+		return syscall.SIGUSR1, nil
+	case "USR2":
+		return syscall.SIGUSR2, nil
+	default:
+		return 0, errors.New("unsupported signal " + s)
+	}
+}
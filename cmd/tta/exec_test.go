@@ -0,0 +1,96 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/binary"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestWriteExecFrame(t *testing.T) {
+	tests := []struct {
+		name     string
+		streamID byte
+		payload  []byte
+	}{
+		{"stdout", execStreamStdout, []byte("hello\n")},
+		{"empty payload", execStreamStderr, nil},
+		{"stdin ack", execStreamStdinAck, []byte(`{"bytes":5}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if err := writeExecFrame(rec, tt.streamID, tt.payload); err != nil {
+				t.Fatalf("writeExecFrame: %v", err)
+			}
+			got := rec.Body.Bytes()
+			if len(got) < 5 {
+				t.Fatalf("frame too short: %d bytes", len(got))
+			}
+			if got[0] != tt.streamID {
+				t.Errorf("stream id = %d, want %d", got[0], tt.streamID)
+			}
+			gotLen := binary.BigEndian.Uint32(got[1:5])
+			if int(gotLen) != len(tt.payload) {
+				t.Errorf("length = %d, want %d", gotLen, len(tt.payload))
+			}
+			if string(got[5:]) != string(tt.payload) {
+				t.Errorf("payload = %q, want %q", got[5:], tt.payload)
+			}
+		})
+	}
+}
+
+func TestFrameWriterSeparatesStreams(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var mu sync.Mutex
+	stdout := frameWriter{rec, execStreamStdout, &mu}
+	stderr := frameWriter{rec, execStreamStderr, &mu}
+
+	if _, err := stdout.Write([]byte("out")); err != nil {
+		t.Fatalf("stdout.Write: %v", err)
+	}
+	if _, err := stderr.Write([]byte("err")); err != nil {
+		t.Fatalf("stderr.Write: %v", err)
+	}
+
+	got := rec.Body.Bytes()
+	if got[0] != execStreamStdout || string(got[5:8]) != "out" {
+		t.Errorf("first frame = %v, want stdout frame for %q", got[:8], "out")
+	}
+	second := got[8:]
+	if second[0] != execStreamStderr || string(second[5:8]) != "err" {
+		t.Errorf("second frame = %v, want stderr frame for %q", second[:8], "err")
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{"", syscall.SIGTERM, false},
+		{"TERM", syscall.SIGTERM, false},
+		{"KILL", syscall.SIGKILL, false},
+		{"INT", syscall.SIGINT, false},
+		{"HUP", syscall.SIGHUP, false},
+		{"USR1", syscall.SIGUSR1, false},
+		{"USR2", syscall.SIGUSR2, false},
+		{"BOGUS", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSignal(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSignal(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parseSignal(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// pcapStreamStarter starts a packet capture filtered by a BPF expression,
+// invoking emit with each captured frame pre-encoded as a pcapng
+// Enhanced Packet Block so the driver can tee /events straight into
+// Wireshark. It's set by pcap_linux.go on platforms that support it.
+var pcapStreamStarter func(ctx context.Context, bpf string, emit func(seq uint64, frame []byte) error) (stop func(), err error)
+
+// startPcapStream starts a packet capture for the lifetime of ctx (or
+// until the returned stop func is called), delivering pcapng-framed
+// packets to emit. It returns an error if packet capture isn't supported
+// on this platform or build.
+func startPcapStream(ctx context.Context, bpf string, emit func(seq uint64, frame []byte) error) (stop func(), err error) {
+	if pcapStreamStarter == nil {
+		return nil, errors.New("packet capture not supported on this platform")
+	}
+	return pcapStreamStarter(ctx, bpf, emit)
+}
@@ -0,0 +1,92 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// pcapInterface is the interface captured from when a driver asks for
+// pcap events. tta runs inside a single-interface test VM, so there's no
+// need to let the driver pick one.
+const pcapInterface = "tailscale0"
+
+func init() {
+	pcapStreamStarter = startPcapStreamLinux
+}
+
+func startPcapStreamLinux(ctx context.Context, bpf string, emit func(seq uint64, frame []byte) error) (stop func(), err error) {
+	h, err := pcap.OpenLive(pcapInterface, 1<<18, true, pcap.BlockForever)
+	if err != nil {
+		return nil, err
+	}
+	if bpf != "" {
+		if err := h.SetBPFFilter(bpf); err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer h.Close()
+		var seq uint64
+		src := gopacket.NewPacketSource(h, h.LinkType())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case pkt, ok := <-src.Packets():
+				if !ok {
+					return
+				}
+				buf, err := encodePcapngBlock(pkt, h.LinkType())
+				if err != nil {
+					continue
+				}
+				seq++
+				if emit(seq, buf) != nil {
+					return
+				}
+			}
+		}
+	}()
+	return cancel, nil
+}
+
+// encodePcapngBlock encodes a single captured packet as a standalone
+// pcapng Enhanced Packet Block (with its own Section Header and
+// Interface Description Block), so each emitted frame is independently
+// parseable by anything reading the /events stream.
+func encodePcapngBlock(pkt gopacket.Packet, linkType layers.LinkType) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := pcapgo.NewNgWriter(&buf, linkType)
+	if err != nil {
+		return nil, err
+	}
+	md := pkt.Metadata()
+	ci := gopacket.CaptureInfo{
+		Timestamp:      md.Timestamp,
+		CaptureLength:  md.CaptureLength,
+		Length:         md.Length,
+		InterfaceIndex: 0,
+	}
+	if ci.Timestamp.IsZero() {
+		ci.Timestamp = time.Now()
+	}
+	if err := w.WritePacket(ci, pkt.Data()); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
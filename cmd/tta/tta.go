@@ -28,11 +28,11 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/net/http2"
 	"tailscale.com/atomicfile"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/hostinfo"
 	"tailscale.com/util/must"
-	"tailscale.com/util/set"
 	"tailscale.com/version/distro"
 )
 
@@ -132,31 +132,7 @@ func main() {
 		}
 		ttaMux.ServeHTTP(w, r)
 	})
-	var hs http.Server
-	hs.Handler = &serveMux
-	var (
-		stMu   sync.Mutex
-		newSet = set.Set[net.Conn]{} // conns in StateNew
-	)
-	needConnCh := make(chan bool, 1)
-	hs.ConnState = func(c net.Conn, s http.ConnState) {
-		stMu.Lock()
-		defer stMu.Unlock()
-		oldLen := len(newSet)
-		switch s {
-		case http.StateNew:
-			newSet.Add(c)
-		default:
-			newSet.Delete(c)
-		}
-		if oldLen != 0 && len(newSet) == 0 {
-			select {
-			case needConnCh <- true:
-			default:
-			}
-		}
-	}
-	conns := make(chan net.Conn, 1)
+	h2s := &http2.Server{}
 
 	lcRP := httputil.NewSingleHostReverseProxy(must.Get(url.Parse("http://local-tailscaled.sock")))
 	lcRP.Transport = new(localClientRoundTripper)
@@ -175,13 +151,17 @@ func main() {
 		serveCmd(w, "tailscale", "up", "--login-server=http://control.tailscale")
 	})
 	ttaMux.HandleFunc("/fw", addFirewallHandler)
+	ttaMux.HandleFunc("/exec", handleExec)
+	ttaMux.HandleFunc("/exec/", handleExecSub)
+	ttaMux.HandleFunc("/events", handleEvents)
+	ttaMux.HandleFunc("/dns/resolve", handleDNSResolve)
+	startEventSources()
 	ttaMux.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
 		logBuf.mu.Lock()
 		defer logBuf.mu.Unlock()
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.Write(logBuf.buf.Bytes())
 	})
-	go hs.Serve(chanListener(conns))
 
 	// For doing agent operations locally from gokrazy:
 	// (e.g. with "wget -O - localhost:8123/fw" or "wget -O - localhost:8123/logs"
@@ -194,9 +174,7 @@ func main() {
 	}()
 
 	var lastErr string
-	needConnCh <- true
 	for {
-		<-needConnCh
 		c, err := connect()
 		if err != nil {
 			s := err.Error()
@@ -207,7 +185,14 @@ func main() {
 			time.Sleep(time.Second)
 			continue
 		}
-		conns <- c
+		lastErr = ""
+		// Serve the single reverse-dialed TCP connection as an h2c (HTTP/2
+		// without TLS) connection, so the driver can multiplex many
+		// concurrent requests (including long-lived /events streams)
+		// over it instead of us having to re-dial for every request.
+		// ServeConn blocks until the driver disconnects, at which point we
+		// loop around and re-dial.
+		h2s.ServeConn(c, &http2.ServeConnOpts{Handler: &serveMux})
 	}
 }
 
@@ -219,27 +204,6 @@ func connect() (net.Conn, error) {
 	return c, nil
 }
 
-type chanListener <-chan net.Conn
-
-func (cl chanListener) Accept() (net.Conn, error) {
-	c, ok := <-cl
-	if !ok {
-		return nil, errors.New("closed")
-	}
-	return c, nil
-}
-
-func (cl chanListener) Close() error {
-	return nil
-}
-
-func (cl chanListener) Addr() net.Addr {
-	return &net.TCPAddr{
-		IP:   net.ParseIP("52.0.0.34"), // TS..DR(iver)
-		Port: 123,
-	}
-}
-
 func addFirewallHandler(w http.ResponseWriter, r *http.Request) {
 	if addFirewall == nil {
 		http.Error(w, "firewall not supported", 500)
@@ -274,4 +238,4 @@ func (lb *logBuffer) Write(p []byte) (n int, err error) {
 		return len(p), nil
 	}
 	return lb.buf.Write(p)
-}
\ No newline at end of file
+}